@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// This file implements the low-level OAuth2 token exchanges used by the GCR
+// and ACR providers in providers.go. They are kept separate from the
+// provider types themselves since they talk to raw HTTP endpoints rather
+// than a cloud SDK.
+
+const (
+	googleMetadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+	googleOAuthTokenURL    = "https://oauth2.googleapis.com/token"
+	acrExchangeURLTemplate = "https://%s/oauth2/exchange"
+)
+
+type oauthTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// parseOAuthTokenResponse decodes a {access_token, expires_in} response body
+// shared by the Google, Azure AD and GCE/Azure metadata token endpoints,
+// turning expires_in into an absolute expiry.
+func parseOAuthTokenResponse(resp *http.Response) (string, time.Time, error) {
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", time.Time{}, fmt.Errorf("token endpoint returned %d", resp.StatusCode)
+	}
+	var tok oauthTokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil {
+		return "", time.Time{}, err
+	}
+	return tok.AccessToken, time.Now().Add(time.Duration(tok.ExpiresIn) * time.Second), nil
+}
+
+// fetchGoogleMetadataToken retrieves an access token for the instance's
+// attached service account from the GCE/GKE metadata server.
+func fetchGoogleMetadataToken() (string, time.Time, error) {
+	req, err := http.NewRequest("GET", googleMetadataTokenURL, nil)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return parseOAuthTokenResponse(resp)
+}
+
+// googleServiceAccountKey is the subset of a GCP service-account JSON key
+// file needed to mint a self-signed JWT assertion for the OAuth2 token
+// exchange.
+type googleServiceAccountKey struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+// exchangeGoogleServiceAccount trades a service-account JSON key for an
+// OAuth2 access token scoped for pulling from GCR/Artifact Registry.
+func exchangeGoogleServiceAccount(keyJSON []byte) (string, time.Time, error) {
+	var key googleServiceAccountKey
+	if err := json.Unmarshal(keyJSON, &key); err != nil {
+		return "", time.Time{}, fmt.Errorf("parsing service account key: %v", err)
+	}
+	assertion, err := signGoogleJWTAssertion(key)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("signing JWT assertion: %v", err)
+	}
+	tokenURI := key.TokenURI
+	if tokenURI == "" {
+		tokenURI = googleOAuthTokenURL
+	}
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	}
+	resp, err := http.PostForm(tokenURI, form)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return parseOAuthTokenResponse(resp)
+}
+
+// signGoogleJWTAssertion builds and signs the self-signed JWT used in the
+// OAuth2 service-account flow (RFC 7523), scoped for read-only registry pulls.
+func signGoogleJWTAssertion(key googleServiceAccountKey) (string, error) {
+	return signJWTWithRS256(key.ClientEmail, key.PrivateKey, googleOAuthTokenURL, "https://www.googleapis.com/auth/devstorage.read_only")
+}
+
+// acrRefreshTokenFromServicePrincipal exchanges an Azure AD service-principal
+// access token for an ACR refresh token via the registry's /oauth2/exchange
+// endpoint.
+func acrRefreshTokenFromServicePrincipal(registry, tenantID, clientID, clientSecret string) (string, time.Time, error) {
+	aadToken, expiresAt, err := azureADClientCredentialsToken(tenantID, clientID, clientSecret, "https://management.azure.com/.default")
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	refreshToken, err := acrExchangeAADToken(registry, tenantID, aadToken)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return refreshToken, expiresAt, nil
+}
+
+// acrRefreshTokenFromMSI is the managed-identity equivalent of
+// acrRefreshTokenFromServicePrincipal: it fetches an AAD token from the
+// instance metadata service instead of a client ID/secret pair.
+func acrRefreshTokenFromMSI(registry string) (string, time.Time, error) {
+	aadToken, expiresAt, err := azureInstanceMetadataToken("https://management.azure.com/")
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	refreshToken, err := acrExchangeAADToken(registry, "", aadToken)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return refreshToken, expiresAt, nil
+}
+
+// acrExchangeAADToken trades an Azure AD access token for an ACR refresh
+// token scoped to the target registry.
+func acrExchangeAADToken(registry, tenantID, aadToken string) (string, error) {
+	form := url.Values{
+		"grant_type":   {"access_token"},
+		"service":      {registry},
+		"tenant":       {tenantID},
+		"access_token": {aadToken},
+	}
+	resp, err := http.PostForm(fmt.Sprintf(acrExchangeURLTemplate, registry), form)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("acr exchange endpoint returned %d", resp.StatusCode)
+	}
+	var body struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	return body.RefreshToken, nil
+}
+
+// azureADClientCredentialsToken runs the OAuth2 client-credentials flow
+// against Azure AD for the given tenant/client/secret and scope.
+func azureADClientCredentialsToken(tenantID, clientID, clientSecret, scope string) (string, time.Time, error) {
+	endpoint := fmt.Sprintf("https://login.microsoftonline.com/%s/oauth2/v2.0/token", tenantID)
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {clientID},
+		"client_secret": {clientSecret},
+		"scope":         {scope},
+	}
+	resp, err := http.PostForm(endpoint, form)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return parseOAuthTokenResponse(resp)
+}
+
+// azureInstanceMetadataToken fetches an AAD token for the VM/pod's managed
+// identity from the Azure Instance Metadata Service.
+func azureInstanceMetadataToken(resource string) (string, time.Time, error) {
+	endpoint := "http://169.254.169.254/metadata/identity/oauth2/token?api-version=2018-02-01&resource=" + url.QueryEscape(resource)
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	req.Header.Set("Metadata", "true")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return parseOAuthTokenResponse(resp)
+}
+
+// signJWTWithRS256 is a minimal RS256 JWT signer used to build the
+// self-signed assertion required by Google's service-account OAuth2 flow.
+func signJWTWithRS256(issuer, pemPrivateKey, audience, scope string) (string, error) {
+	now := time.Now()
+	header := `{"alg":"RS256","typ":"JWT"}`
+	claims := fmt.Sprintf(
+		`{"iss":%q,"scope":%q,"aud":%q,"iat":%d,"exp":%d}`,
+		issuer, scope, audience, now.Unix(), now.Add(time.Hour).Unix(),
+	)
+	signingInput := strings.Join([]string{base64URLEncode([]byte(header)), base64URLEncode([]byte(claims))}, ".")
+	signature, err := rsaSignPKCS1v15SHA256(pemPrivateKey, signingInput)
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + signature, nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseOAuthTokenResponse(t *testing.T) {
+	t.Run("decodes access_token and expires_in", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"access_token":"tok-123","expires_in":3600}`)
+		}))
+		defer server.Close()
+
+		resp, err := http.Get(server.URL)
+		if err != nil {
+			t.Fatalf("GET: %v", err)
+		}
+
+		before := time.Now()
+		token, expiresAt, err := parseOAuthTokenResponse(resp)
+		if err != nil {
+			t.Fatalf("parseOAuthTokenResponse: %v", err)
+		}
+		if token != "tok-123" {
+			t.Fatalf("token = %q, want %q", token, "tok-123")
+		}
+		wantExpiry := before.Add(3600 * time.Second)
+		if diff := expiresAt.Sub(wantExpiry); diff < -5*time.Second || diff > 5*time.Second {
+			t.Fatalf("expiresAt = %s, want ~%s", expiresAt, wantExpiry)
+		}
+	})
+
+	t.Run("propagates a non-200 status as an error", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusUnauthorized)
+		}))
+		defer server.Close()
+
+		resp, err := http.Get(server.URL)
+		if err != nil {
+			t.Fatalf("GET: %v", err)
+		}
+
+		if _, _, err := parseOAuthTokenResponse(resp); err == nil {
+			t.Fatalf("expected an error for a 401 response")
+		}
+	})
+
+	t.Run("propagates a malformed body as an error", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `not json`)
+		}))
+		defer server.Close()
+
+		resp, err := http.Get(server.URL)
+		if err != nil {
+			t.Fatalf("GET: %v", err)
+		}
+
+		if _, _, err := parseOAuthTokenResponse(resp); err == nil {
+			t.Fatalf("expected an error for a malformed body")
+		}
+	})
+}
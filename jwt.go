@@ -0,0 +1,55 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+)
+
+// base64URLEncode encodes data using unpadded base64url, as required by the
+// JWT spec.
+func base64URLEncode(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// rsaSignPKCS1v15SHA256 signs signingInput with the RSA private key found in
+// pemPrivateKey (PKCS#1 or PKCS#8, PEM-encoded) and returns the base64url
+// signature, as used by RS256 JWTs.
+func rsaSignPKCS1v15SHA256(pemPrivateKey, signingInput string) (string, error) {
+	block, _ := pem.Decode([]byte(pemPrivateKey))
+	if block == nil {
+		return "", fmt.Errorf("no PEM block found in private key")
+	}
+	key, err := parseRSAPrivateKey(block.Bytes)
+	if err != nil {
+		return "", err
+	}
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return base64URLEncode(signature), nil
+}
+
+// parseRSAPrivateKey accepts either a PKCS#1 or PKCS#8 encoded RSA key, since
+// Google service-account JSON keys use PKCS#8.
+func parseRSAPrivateKey(der []byte) (*rsa.PrivateKey, error) {
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("parsing RSA private key: %v", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key is not RSA")
+	}
+	return key, nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"strings"
+	"testing"
+)
+
+func generateTestRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating RSA key: %v", err)
+	}
+	return key
+}
+
+func pemEncodePKCS1(t *testing.T, key *rsa.PrivateKey) string {
+	t.Helper()
+	return string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))
+}
+
+func pemEncodePKCS8(t *testing.T, key *rsa.PrivateKey) string {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshaling PKCS8 key: %v", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+}
+
+func TestParseRSAPrivateKeyAcceptsPKCS1AndPKCS8(t *testing.T) {
+	key := generateTestRSAKey(t)
+
+	t.Run("PKCS1", func(t *testing.T) {
+		block, _ := pem.Decode([]byte(pemEncodePKCS1(t, key)))
+		got, err := parseRSAPrivateKey(block.Bytes)
+		if err != nil {
+			t.Fatalf("parseRSAPrivateKey: %v", err)
+		}
+		if !got.Equal(key) {
+			t.Fatalf("parsed key does not match the original")
+		}
+	})
+
+	t.Run("PKCS8", func(t *testing.T) {
+		block, _ := pem.Decode([]byte(pemEncodePKCS8(t, key)))
+		got, err := parseRSAPrivateKey(block.Bytes)
+		if err != nil {
+			t.Fatalf("parseRSAPrivateKey: %v", err)
+		}
+		if !got.Equal(key) {
+			t.Fatalf("parsed key does not match the original")
+		}
+	})
+
+	t.Run("garbage input", func(t *testing.T) {
+		if _, err := parseRSAPrivateKey([]byte("not a key")); err == nil {
+			t.Fatalf("expected an error for non-key input")
+		}
+	})
+}
+
+func TestRSASignPKCS1v15SHA256RoundTrips(t *testing.T) {
+	key := generateTestRSAKey(t)
+	pemKey := pemEncodePKCS8(t, key)
+
+	const signingInput = "header.payload"
+	sigB64, err := rsaSignPKCS1v15SHA256(pemKey, signingInput)
+	if err != nil {
+		t.Fatalf("rsaSignPKCS1v15SHA256: %v", err)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(sigB64)
+	if err != nil {
+		t.Fatalf("decoding signature: %v", err)
+	}
+
+	digest := sha256.Sum256([]byte(signingInput))
+	if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], signature); err != nil {
+		t.Fatalf("signature did not verify against the public key: %v", err)
+	}
+}
+
+func TestSignJWTWithRS256ProducesAVerifiableToken(t *testing.T) {
+	key := generateTestRSAKey(t)
+	pemKey := pemEncodePKCS8(t, key)
+
+	token, err := signJWTWithRS256("svc@example.iam.gserviceaccount.com", pemKey, "https://oauth2.googleapis.com/token", "scope-a scope-b")
+	if err != nil {
+		t.Fatalf("signJWTWithRS256: %v", err)
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("expected a 3-part JWT, got %d parts", len(parts))
+	}
+
+	signingInput := parts[0] + "." + parts[1]
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		t.Fatalf("decoding signature: %v", err)
+	}
+	digest := sha256.Sum256([]byte(signingInput))
+	if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], signature); err != nil {
+		t.Fatalf("JWT signature did not verify: %v", err)
+	}
+
+	header, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		t.Fatalf("decoding header: %v", err)
+	}
+	if !strings.Contains(string(header), `"RS256"`) {
+		t.Fatalf("expected header to declare RS256, got %s", header)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+const (
+	leaderElectionLeaseDuration = 15 * time.Second
+	leaderElectionRenewDeadline = 10 * time.Second
+	leaderElectionRetryPeriod   = 2 * time.Second
+)
+
+// RunWithLeaderElection runs run only while this process holds the named
+// lock, so multiple replicas can be deployed for HA without racing on the
+// same secret/ServiceAccount updates. It blocks while this process leads;
+// losing leadership logs via logrus.Fatalf, which exits the process. run
+// receives the leader election's stop channel.
+func RunWithLeaderElection(client *kubernetes.Clientset, lockNamespace, lockName string, run func(stop <-chan struct{})) error {
+	identity, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+
+	lock, err := resourcelock.New(
+		resourcelock.ConfigMapsResourceLock,
+		lockNamespace,
+		lockName,
+		client.Core(),
+		resourcelock.ResourceLockConfig{
+			Identity: identity,
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	leaderelection.RunOrDie(leaderelection.LeaderElectionConfig{
+		Lock:          lock,
+		LeaseDuration: leaderElectionLeaseDuration,
+		RenewDeadline: leaderElectionRenewDeadline,
+		RetryPeriod:   leaderElectionRetryPeriod,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(stop <-chan struct{}) {
+				logrus.Infof("%s acquired leadership of %s/%s, starting reconcile loop", identity, lockNamespace, lockName)
+				setReady(true)
+				run(stop)
+			},
+			OnStoppedLeading: func() {
+				setReady(false)
+				logrus.Fatalf("%s lost leadership of %s/%s, exiting", identity, lockNamespace, lockName)
+			},
+		},
+	})
+	return nil
+}
@@ -2,27 +2,18 @@ package main
 
 import (
 	"flag"
+	"strings"
 
 	"github.com/Sirupsen/logrus"
 
-	"github.com/aws/aws-sdk-go/aws/session"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 
 	"fmt"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/service/ecr"
-	"k8s.io/client-go/tools/cache"
-	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/client-go/pkg/api/v1"
-	"k8s.io/client-go/pkg/fields"
-)
-
-const (
-	dockerJSONTemplate = `{"auths":{"%s":{"auth":"%s","email":"none"}}}`
+	"k8s.io/client-go/tools/clientcmd"
 )
 
 type config struct {
@@ -32,6 +23,67 @@ type config struct {
 	AWSSecretAccessKey string
 	AWSRegion          string
 	RefreshInterval    int
+	// ECRAccounts configures cross-account/region ECR targets; see the
+	// --ecr-accounts flag for its format. Empty means a single account in
+	// AWSRegion with no role assumption.
+	ECRAccounts string
+
+	// GCRRegistry and GCRServiceAccountJSON configure an optional GCR/Artifact
+	// Registry provider. When GCRServiceAccountJSON is empty, workload
+	// identity is used instead.
+	GCRRegistry           string
+	GCRServiceAccountJSON string
+
+	// ACR* configure an optional Azure Container Registry provider.
+	ACRRegistry     string
+	ACRTenantID     string
+	ACRClientID     string
+	ACRClientSecret string
+	ACRUseMSI       bool
+
+	// DockerHub* configure an optional static Docker Hub provider.
+	DockerHubUsername string
+	DockerHubPassword string
+
+	// NamespaceSelector, if set, restricts management to namespaces whose
+	// labels match this selector expression (e.g. "team=platform").
+	NamespaceSelector string
+	// RequireNamespaceAnnotation, when true, only manages namespaces
+	// annotated with ecr-renew.io/enabled=true.
+	RequireNamespaceAnnotation bool
+	// Namespaces is a comma-separated allow/deny list, e.g. "foo,bar,!baz".
+	// Entries prefixed with "!" are always excluded.
+	Namespaces string
+	// SingleNamespace, when true, skips the cluster-wide namespace informer
+	// entirely and only manages the secret in the pod's own namespace, as
+	// discovered from the mounted service account token.
+	SingleNamespace bool
+
+	// RefreshSafetyMargin is the fraction of a credential's remaining
+	// lifetime to wait before refreshing it, e.g. 0.75 refreshes a 12h ECR
+	// token after 9h rather than on every resync.
+	RefreshSafetyMargin float64
+	// MaxBackoff caps the exponential backoff applied between retries after
+	// a provider refresh fails.
+	MaxBackoff time.Duration
+
+	// ServiceAccounts is a comma-separated list of ServiceAccount names to
+	// reconcile, e.g. "default,builder". Takes precedence over
+	// ServiceAccountSelector. Defaults to "default" when both are empty.
+	ServiceAccounts string
+	// ServiceAccountSelector reconciles every ServiceAccount in a namespace
+	// matching this label selector instead of an explicit name list.
+	ServiceAccountSelector string
+
+	// MetricsAddr is the address the /metrics, /healthz and /readyz HTTP
+	// endpoints are served on.
+	MetricsAddr string
+
+	// LeaderElect, when true, only reconciles while holding a lock in
+	// LeaderElectionNamespace, so multiple replicas can run for HA.
+	LeaderElect             bool
+	LeaderElectionNamespace string
+	LeaderElectionID        string
 }
 
 func parse() config {
@@ -44,9 +96,148 @@ func parse() config {
 	var awsSecretAccessKey = flag.String("aws_secret_access_key", "", "")
 	var awsRegion = flag.String("aws_region", "", "")
 	var refreshInterval = flag.Int("refresh-interval", 60, "")
+	var ecrAccounts = flag.String("ecr-accounts", "", "comma-separated cross-account ECR targets as 'region|roleArn|externalId|sessionName' (roleArn/externalId/sessionName optional); defaults to a single entry using -aws_region with no role assumption")
+
+	var gcrRegistry = flag.String("gcr-registry", "", "GCR/Artifact Registry hostname, e.g. gcr.io (enables the GCR provider)")
+	var gcrServiceAccountJSON = flag.String("gcr-service-account-json", "", "path to a GCP service-account JSON key; empty uses workload identity")
+
+	var acrRegistry = flag.String("acr-registry", "", "ACR login server, e.g. myregistry.azurecr.io (enables the ACR provider)")
+	var acrTenantID = flag.String("acr-tenant-id", "", "Azure AD tenant ID for the ACR service principal")
+	var acrClientID = flag.String("acr-client-id", "", "Azure AD client ID for the ACR service principal")
+	var acrClientSecret = flag.String("acr-client-secret", "", "Azure AD client secret for the ACR service principal")
+	var acrUseMSI = flag.Bool("acr-use-msi", false, "authenticate to ACR using the node's managed identity instead of a service principal")
+
+	var dockerHubUsername = flag.String("dockerhub-username", "", "Docker Hub username (enables the Docker Hub provider)")
+	var dockerHubPassword = flag.String("dockerhub-password", "", "Docker Hub password or access token")
+
+	var namespaceSelector = flag.String("namespace-selector", "", "only manage namespaces matching this label selector")
+	var requireNamespaceAnnotation = flag.Bool("namespace-require-annotation", false, "only manage namespaces annotated with "+namespaceEnabledAnnotation+"=true")
+	var namespaces = flag.String("namespaces", "", "comma-separated namespace allow/deny list, e.g. 'foo,bar,!baz'")
+	var singleNamespace = flag.Bool("single-namespace", false, "only manage the secret in the pod's own namespace; skips the cluster-wide namespace informer")
+
+	var refreshSafetyMargin = flag.Float64("refresh-safety-margin", 0.75, "fraction of a credential's remaining lifetime to wait before refreshing it")
+	var maxBackoff = flag.Duration("max-backoff", 5*time.Minute, "maximum backoff between retries after a provider refresh fails")
+
+	var serviceAccounts = flag.String("service-accounts", "", "comma-separated ServiceAccount names to reconcile, e.g. 'default,builder' (defaults to 'default')")
+	var serviceAccountSelector = flag.String("service-account-selector", "", "reconcile every ServiceAccount matching this label selector instead of --service-accounts")
+
+	var metricsAddr = flag.String("metrics-addr", ":9090", "address to serve /metrics, /healthz and /readyz on")
+
+	var leaderElect = flag.Bool("leader-elect", false, "only reconcile while holding a leader election lock, for HA deployments with multiple replicas")
+	var leaderElectionNamespace = flag.String("leader-election-namespace", "kube-system", "namespace holding the leader election lock")
+	var leaderElectionID = flag.String("leader-election-id", "ecr-renew-leader", "name of the leader election lock")
+
 	flag.Parse()
-	return config{*kubecfg, *kubeMasterURL, *awsAccessKeyID,
-		*awsSecretAccessKey, *awsRegion, *refreshInterval}
+	return config{
+		Kubecfg:                    *kubecfg,
+		KubeMasterURL:              *kubeMasterURL,
+		AWSAccessKeyID:             *awsAccessKeyID,
+		AWSSecretAccessKey:         *awsSecretAccessKey,
+		AWSRegion:                  *awsRegion,
+		RefreshInterval:            *refreshInterval,
+		ECRAccounts:                *ecrAccounts,
+		GCRRegistry:                *gcrRegistry,
+		GCRServiceAccountJSON:      *gcrServiceAccountJSON,
+		ACRRegistry:                *acrRegistry,
+		ACRTenantID:                *acrTenantID,
+		ACRClientID:                *acrClientID,
+		ACRClientSecret:            *acrClientSecret,
+		ACRUseMSI:                  *acrUseMSI,
+		DockerHubUsername:          *dockerHubUsername,
+		DockerHubPassword:          *dockerHubPassword,
+		NamespaceSelector:          *namespaceSelector,
+		RequireNamespaceAnnotation: *requireNamespaceAnnotation,
+		Namespaces:                 *namespaces,
+		SingleNamespace:            *singleNamespace,
+		RefreshSafetyMargin:        *refreshSafetyMargin,
+		MaxBackoff:                 *maxBackoff,
+		ServiceAccounts:            *serviceAccounts,
+		ServiceAccountSelector:     *serviceAccountSelector,
+		MetricsAddr:                *metricsAddr,
+		LeaderElect:                *leaderElect,
+		LeaderElectionNamespace:    *leaderElectionNamespace,
+		LeaderElectionID:           *leaderElectionID,
+	}
+}
+
+// buildProviders assembles the list of RegistryCredentialProvider instances
+// enabled by cfg. AWS ECR is always enabled; GCR, ACR and Docker Hub are
+// opt-in based on which flags were set.
+func buildProviders(cfg config) ([]RegistryCredentialProvider, error) {
+	ecrAccounts, err := parseECRAccounts(cfg.ECRAccounts, cfg.AWSRegion)
+	if err != nil {
+		return nil, fmt.Errorf("parsing --ecr-accounts: %v", err)
+	}
+	providers := []RegistryCredentialProvider{&ECRProvider{Accounts: ecrAccounts}}
+
+	if cfg.GCRRegistry != "" {
+		providers = append(providers, &GCRProvider{
+			Registry:               cfg.GCRRegistry,
+			ServiceAccountJSONPath: cfg.GCRServiceAccountJSON,
+		})
+	}
+
+	if cfg.ACRRegistry != "" {
+		providers = append(providers, &ACRProvider{
+			Registry:     cfg.ACRRegistry,
+			TenantID:     cfg.ACRTenantID,
+			ClientID:     cfg.ACRClientID,
+			ClientSecret: cfg.ACRClientSecret,
+			UseMSI:       cfg.ACRUseMSI,
+		})
+	}
+
+	if cfg.DockerHubUsername != "" {
+		providers = append(providers, &DockerHubProvider{
+			Username: cfg.DockerHubUsername,
+			Password: cfg.DockerHubPassword,
+		})
+	}
+
+	names := make([]string, len(providers))
+	for i, p := range providers {
+		names[i] = p.Name()
+		providers[i] = newScheduledProvider(p, cfg.RefreshSafetyMargin, cfg.MaxBackoff)
+	}
+	logrus.Infof("Configured registry providers: %s", strings.Join(names, ", "))
+	return providers, nil
+}
+
+// renderDockerConfig queries every provider for fresh credentials and
+// merges them into a single .dockerconfigjson document.
+func renderDockerConfig(providers []RegistryCredentialProvider) ([]byte, error) {
+	var auths []*RegistryAuth
+	for _, p := range providers {
+		a, err := p.GetAuth()
+		if err != nil {
+			return nil, fmt.Errorf("provider %s: %v", p.Name(), err)
+		}
+		auths = append(auths, a...)
+	}
+
+	dockerConfig, err := buildDockerConfigJSON(auths)
+	if err != nil {
+		return nil, fmt.Errorf("rendering dockerconfigjson: %v", err)
+	}
+	return dockerConfig, nil
+}
+
+// buildPullSecret queries every provider for fresh credentials and merges
+// them into a single kubernetes.io/dockerconfigjson secret.
+func buildPullSecret(providers []RegistryCredentialProvider, secretName string) (*v1.Secret, error) {
+	dockerConfig, err := renderDockerConfig(providers)
+	if err != nil {
+		return nil, err
+	}
+
+	secret := &v1.Secret{
+		ObjectMeta: v1.ObjectMeta{
+			Name: secretName,
+		},
+	}
+	secret.Data = map[string][]byte{".dockerconfigjson": dockerConfig}
+	secret.Type = "kubernetes.io/dockerconfigjson"
+	return secret, nil
 }
 
 func NewKubeClient(kubeCfgFile string) (*kubernetes.Clientset, error) {
@@ -85,62 +276,6 @@ func NewKubeClient(kubeCfgFile string) (*kubernetes.Clientset, error) {
 	return client, nil
 }
 
-func getECRSecret(region string, secretName string) *v1.Secret {
-	logrus.Infof("Fetching ECR token for region: %s", region)
-	sesh := session.Must(session.NewSession())
-	awscfg := aws.NewConfig().WithRegion(region)
-	ecrClient := ecr.New(sesh, awscfg)
-	input := &ecr.GetAuthorizationTokenInput{}
-	result, err := ecrClient.GetAuthorizationToken(input)
-	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case ecr.ErrCodeServerException:
-				fmt.Println(ecr.ErrCodeServerException, aerr.Error())
-			case ecr.ErrCodeInvalidParameterException:
-				fmt.Println(ecr.ErrCodeInvalidParameterException, aerr.Error())
-			default:
-				fmt.Println(aerr.Error())
-			}
-		}
-	}
-	token := *result.AuthorizationData[0].AuthorizationToken
-	endpoint := *result.AuthorizationData[0].ProxyEndpoint
-	secret := &v1.Secret{
-		ObjectMeta: v1.ObjectMeta{
-			Name: secretName,
-		},
-	}
-	secret.Data = map[string][]byte{
-		".dockerconfigjson": []byte(fmt.Sprintf(dockerJSONTemplate, endpoint, token))}
-	secret.Type = "kubernetes.io/dockerconfigjson"
-	return secret
-}
-
-// Watches all namespaces for changes, executing handler on change.
-func WatchNamespaces(client *kubernetes.Clientset, resyncPeriod time.Duration,
-	handler func(namespace *v1.Namespace) error) {
-	killChan := make(chan struct{})
-	_, informer := cache.NewInformer(
-		cache.NewListWatchFromClient(client.Core().RESTClient(), "namespaces", v1.NamespaceAll, fields.Everything()),
-		&v1.Namespace{},
-		resyncPeriod,
-		cache.ResourceEventHandlerFuncs{
-			AddFunc: func(obj interface{}) {
-				if err := handler(obj.(*v1.Namespace)); err != nil {
-					logrus.Info(err)
-				}
-			},
-			UpdateFunc: func(_ interface{}, obj interface{}) {
-				if err := handler(obj.(*v1.Namespace)); err != nil {
-					logrus.Info(err)
-				}
-			},
-		},
-	)
-	informer.Run(killChan)
-}
-
 func main() {
 	secretName := "ecrsecret"
 	cfg := parse()
@@ -149,55 +284,50 @@ func main() {
 	if err != nil {
 		logrus.Error("Could not create client,", err)
 	}
-	WatchNamespaces(client, time.Duration(1)*time.Minute, func(ns *v1.Namespace) error {
-		if ns.GetDeletionTimestamp() == nil {
-
-			// 2 Update existing service account
-			newSecret := getECRSecret(cfg.AWSRegion, secretName)
-			// Update secret if it already exists
-			_, err := client.Secrets(ns.GetName()).Get(secretName)
-			if err == nil {
-				logrus.Infof("Found existing secret in ns: %s, updating...", ns.GetName())
-				_, updateErr := client.Secrets(ns.GetName()).Update(newSecret)
-				if updateErr != nil {
-					logrus.Errorf("Error creating secret in ns %s: %s", ns.GetName(), err)
-				}
-			} else {
-				logrus.Infof("Secret does not exist in ns: %s, creating...", ns.GetName())
-				_, createErr := client.Secrets(ns.GetName()).Create(newSecret)
-				if createErr != nil {
-					logrus.Errorf("Error creating secret in ns %s: ", ns.GetName(), err)
-					return err
-				}
-			}
+	providers, err := buildProviders(cfg)
+	if err != nil {
+		logrus.Fatalf("Could not configure registry providers: %v", err)
+	}
 
-			// Ensure that the default service account exists
-			defaultServiceAcccont, defaultServiceErr :=
-				client.ServiceAccounts(ns.GetName()).Get("default")
-			if err != defaultServiceErr {
-				logrus.Errorf("Could not get ServiceAccounts! %v", err)
-			}
-			imagePullSecretFound := false
-			for i, imagePullSecret := range defaultServiceAcccont.ImagePullSecrets {
-				if imagePullSecret.Name == newSecret.Name {
-					defaultServiceAcccont.ImagePullSecrets[i] = v1.LocalObjectReference{Name: newSecret.Name}
-					imagePullSecretFound = true
-					break
-				}
-			}
+	saNames, saSelector, err := parseServiceAccountTargets(cfg.ServiceAccounts, cfg.ServiceAccountSelector)
+	if err != nil {
+		logrus.Fatalf("Invalid service account targeting configuration: %v", err)
+	}
+	reconciler := NewServiceAccountReconciler(client, providers, secretName, saNames, saSelector)
+	handler := func(ns *v1.Namespace) error {
+		if ns.GetDeletionTimestamp() != nil {
+			return nil
+		}
+		return reconciler.Reconcile(ns.GetName())
+	}
 
-			if !imagePullSecretFound {
-				defaultServiceAcccont.ImagePullSecrets =
-					append(defaultServiceAcccont.ImagePullSecrets, v1.LocalObjectReference{Name: newSecret.Name})
-			}
-			// Update service accounts if they don't contain the secret
+	go ServeMetrics(cfg.MetricsAddr)
 
-			_, err = client.ServiceAccounts(ns.GetName()).Update(defaultServiceAcccont)
-			if err != nil {
-				return fmt.Errorf("Could update ServiceAccount! %v", err)
+	startWatching := func(stop <-chan struct{}) {
+		if cfg.SingleNamespace {
+			namespace, nsErr := currentNamespaceFromServiceAccount()
+			if nsErr != nil {
+				logrus.Fatalf("--single-namespace was set but the current namespace could not be determined: %v", nsErr)
 			}
-			return nil
+			logrus.Infof("Running in single-namespace mode, managing namespace: %s", namespace)
+			WatchSingleNamespace(client, namespace, time.Duration(1)*time.Minute, handler)
+			return
+		}
+
+		filter, filterErr := newNamespaceFilter(cfg.NamespaceSelector, cfg.RequireNamespaceAnnotation, cfg.Namespaces)
+		if filterErr != nil {
+			logrus.Fatalf("Invalid namespace filter configuration: %v", filterErr)
 		}
-		return nil
-	})
+		WatchNamespaces(client, time.Duration(1)*time.Minute, filter, handler)
+	}
+
+	if cfg.LeaderElect {
+		if err := RunWithLeaderElection(client, cfg.LeaderElectionNamespace, cfg.LeaderElectionID, startWatching); err != nil {
+			logrus.Fatalf("Leader election failed: %v", err)
+		}
+		return
+	}
+
+	setReady(true)
+	startWatching(nil)
 }
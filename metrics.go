@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	ecrTokenRefreshTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ecr_token_refresh_total",
+		Help: "Total number of registry credential refresh attempts, by provider and result.",
+	}, []string{"provider", "result"})
+
+	secretReconcileErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "secret_reconcile_errors_total",
+		Help: "Total number of errors reconciling the pull secret in a namespace.",
+	}, []string{"namespace"})
+
+	ecrTokenExpirySeconds = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ecr_token_expiry_seconds",
+		Help: "Unix timestamp at which the most recently fetched token for a registry endpoint expires.",
+	}, []string{"provider", "endpoint"})
+)
+
+func init() {
+	prometheus.MustRegister(ecrTokenRefreshTotal, secretReconcileErrorsTotal, ecrTokenExpirySeconds)
+}
+
+// ready flips to 1 once the controller has acquired leadership (or leader
+// election is disabled) and is actively reconciling, gating /readyz.
+var ready int32
+
+func setReady(v bool) {
+	if v {
+		atomic.StoreInt32(&ready, 1)
+	} else {
+		atomic.StoreInt32(&ready, 0)
+	}
+}
+
+// ServeMetrics starts the /metrics, /healthz and /readyz HTTP endpoints on
+// addr. It's expected to run for the lifetime of the process, so callers
+// should invoke it in its own goroutine.
+func ServeMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&ready) == 1 {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	logrus.Infof("Serving metrics on %s", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		logrus.Errorf("Metrics server exited: %v", err)
+	}
+}
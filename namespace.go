@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/fields"
+	"k8s.io/client-go/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+)
+
+// saNamespaceFile is where kubernetes mounts the namespace of the pod's own
+// service account. It's the same file client-go falls back to when
+// determining the current namespace outside of an explicit --namespace flag.
+const saNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// namespaceEnabledAnnotation lets operators opt individual namespaces in
+// without touching --namespaces or --namespace-selector, by annotating the
+// namespace directly.
+const namespaceEnabledAnnotation = "ecr-renew.io/enabled"
+
+// namespaceFilter decides which namespaces the controller should manage
+// secrets in, combining a label selector, an opt-in annotation and an
+// explicit allow/deny list.
+type namespaceFilter struct {
+	// Selector, when non-nil, restricts management to namespaces matching
+	// this label selector.
+	Selector labels.Selector
+	// RequireAnnotation, when true, only manages namespaces carrying
+	// namespaceEnabledAnnotation="true".
+	RequireAnnotation bool
+	// Allow, if non-empty, is the only set of namespaces that may be
+	// managed; Deny namespaces are always excluded, even if also in Allow.
+	Allow map[string]bool
+	Deny  map[string]bool
+}
+
+// newNamespaceFilter builds a namespaceFilter from the --namespace-selector,
+// --namespaces and --namespace-require-annotation flags.
+func newNamespaceFilter(selectorExpr string, requireAnnotation bool, namespacesFlag string) (*namespaceFilter, error) {
+	filter := &namespaceFilter{RequireAnnotation: requireAnnotation}
+
+	if selectorExpr != "" {
+		selector, err := labels.Parse(selectorExpr)
+		if err != nil {
+			return nil, fmt.Errorf("parsing --namespace-selector: %v", err)
+		}
+		filter.Selector = selector
+	}
+
+	if namespacesFlag != "" {
+		allow := map[string]bool{}
+		deny := map[string]bool{}
+		for _, entry := range strings.Split(namespacesFlag, ",") {
+			entry = strings.TrimSpace(entry)
+			if entry == "" {
+				continue
+			}
+			if strings.HasPrefix(entry, "!") {
+				deny[strings.TrimPrefix(entry, "!")] = true
+			} else {
+				allow[entry] = true
+			}
+		}
+		if len(allow) > 0 {
+			filter.Allow = allow
+		}
+		if len(deny) > 0 {
+			filter.Deny = deny
+		}
+	}
+
+	return filter, nil
+}
+
+// Matches reports whether ns should be managed by this controller.
+func (f *namespaceFilter) Matches(ns *v1.Namespace) bool {
+	if f.Deny[ns.GetName()] {
+		return false
+	}
+	if f.Allow != nil && !f.Allow[ns.GetName()] {
+		return false
+	}
+	if f.Selector != nil && !f.Selector.Matches(labels.Set(ns.GetLabels())) {
+		return false
+	}
+	if f.RequireAnnotation && ns.GetAnnotations()[namespaceEnabledAnnotation] != "true" {
+		return false
+	}
+	return true
+}
+
+// currentNamespaceFromServiceAccount reads the namespace of the pod's own
+// service account from the projected token mount. It mirrors the fallback
+// client-go itself uses when no explicit namespace is configured.
+func currentNamespaceFromServiceAccount() (string, error) {
+	raw, err := ioutil.ReadFile(saNamespaceFile)
+	if err != nil {
+		return "", fmt.Errorf("reading %s: %v", saNamespaceFile, err)
+	}
+	ns := strings.TrimSpace(string(raw))
+	if ns == "" {
+		return "", fmt.Errorf("%s was empty", saNamespaceFile)
+	}
+	return ns, nil
+}
+
+// WatchNamespaces watches all namespaces for changes, executing handler for
+// each one accepted by filter.
+func WatchNamespaces(client *kubernetes.Clientset, resyncPeriod time.Duration,
+	filter *namespaceFilter, handler func(namespace *v1.Namespace) error) {
+	killChan := make(chan struct{})
+	listWatch := cache.NewListWatchFromClient(client.Core().RESTClient(), "namespaces", v1.NamespaceAll, fields.Everything())
+	filtered := func(ns *v1.Namespace) error {
+		if !filter.Matches(ns) {
+			return nil
+		}
+		return handler(ns)
+	}
+	_, informer := cache.NewInformer(
+		listWatch,
+		&v1.Namespace{},
+		resyncPeriod,
+		cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				if err := filtered(obj.(*v1.Namespace)); err != nil {
+					logrus.Info(err)
+				}
+			},
+			UpdateFunc: func(_ interface{}, obj interface{}) {
+				if err := filtered(obj.(*v1.Namespace)); err != nil {
+					logrus.Info(err)
+				}
+			},
+		},
+	)
+	informer.Run(killChan)
+}
+
+// WatchSingleNamespace runs handler for a single namespace on resyncPeriod,
+// without ever starting a cluster-wide namespace informer. It's intended for
+// --single-namespace deployments where the controller is only granted
+// namespace-scoped RBAC and cannot list/watch Namespaces at all.
+func WatchSingleNamespace(client *kubernetes.Clientset, namespace string, resyncPeriod time.Duration,
+	handler func(namespace *v1.Namespace) error) {
+	ns := &v1.Namespace{ObjectMeta: v1.ObjectMeta{Name: namespace}}
+	ticker := time.NewTicker(resyncPeriod)
+	defer ticker.Stop()
+	if err := handler(ns); err != nil {
+		logrus.Info(err)
+	}
+	for range ticker.C {
+		if err := handler(ns); err != nil {
+			logrus.Info(err)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"testing"
+
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/labels"
+)
+
+func mustParseSelector(t *testing.T, expr string) labels.Selector {
+	t.Helper()
+	selector, err := labels.Parse(expr)
+	if err != nil {
+		t.Fatalf("parsing selector %q: %v", expr, err)
+	}
+	return selector
+}
+
+func TestNamespaceFilterMatches(t *testing.T) {
+	ns := func(name string, lbls, annotations map[string]string) *v1.Namespace {
+		return &v1.Namespace{ObjectMeta: v1.ObjectMeta{Name: name, Labels: lbls, Annotations: annotations}}
+	}
+
+	tests := []struct {
+		name   string
+		filter *namespaceFilter
+		ns     *v1.Namespace
+		want   bool
+	}{
+		{
+			name:   "no restrictions matches everything",
+			filter: &namespaceFilter{},
+			ns:     ns("team-a", nil, nil),
+			want:   true,
+		},
+		{
+			name:   "deny always wins, even if also in allow",
+			filter: &namespaceFilter{Allow: map[string]bool{"team-a": true}, Deny: map[string]bool{"team-a": true}},
+			ns:     ns("team-a", nil, nil),
+			want:   false,
+		},
+		{
+			name:   "allow list excludes anything not listed",
+			filter: &namespaceFilter{Allow: map[string]bool{"team-a": true}},
+			ns:     ns("team-b", nil, nil),
+			want:   false,
+		},
+		{
+			name:   "allow list admits a listed namespace",
+			filter: &namespaceFilter{Allow: map[string]bool{"team-a": true}},
+			ns:     ns("team-a", nil, nil),
+			want:   true,
+		},
+		{
+			name:   "selector rejects a namespace with no matching labels",
+			filter: &namespaceFilter{Selector: mustParseSelector(t, "env=prod")},
+			ns:     ns("team-a", map[string]string{"env": "staging"}, nil),
+			want:   false,
+		},
+		{
+			name:   "selector admits a namespace with matching labels",
+			filter: &namespaceFilter{Selector: mustParseSelector(t, "env=prod")},
+			ns:     ns("team-a", map[string]string{"env": "prod"}, nil),
+			want:   true,
+		},
+		{
+			name:   "RequireAnnotation rejects a namespace missing the annotation",
+			filter: &namespaceFilter{RequireAnnotation: true},
+			ns:     ns("team-a", nil, nil),
+			want:   false,
+		},
+		{
+			name:   "RequireAnnotation rejects the annotation set to anything but \"true\"",
+			filter: &namespaceFilter{RequireAnnotation: true},
+			ns:     ns("team-a", nil, map[string]string{namespaceEnabledAnnotation: "false"}),
+			want:   false,
+		},
+		{
+			name:   "RequireAnnotation admits the annotation set to \"true\"",
+			filter: &namespaceFilter{RequireAnnotation: true},
+			ns:     ns("team-a", nil, map[string]string{namespaceEnabledAnnotation: "true"}),
+			want:   true,
+		},
+		{
+			name: "selector and annotation combine, both must pass",
+			filter: &namespaceFilter{
+				Selector:          mustParseSelector(t, "env=prod"),
+				RequireAnnotation: true,
+			},
+			ns:   ns("team-a", map[string]string{"env": "prod"}, nil),
+			want: false,
+		},
+		{
+			name: "selector and annotation combine, passing both admits",
+			filter: &namespaceFilter{
+				Selector:          mustParseSelector(t, "env=prod"),
+				RequireAnnotation: true,
+			},
+			ns:   ns("team-a", map[string]string{"env": "prod"}, map[string]string{namespaceEnabledAnnotation: "true"}),
+			want: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.Matches(tt.ns); got != tt.want {
+				t.Fatalf("Matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewNamespaceFilterParsesAllowDenyAndSelector(t *testing.T) {
+	filter, err := newNamespaceFilter("env=prod", true, "team-a,team-b,!team-c")
+	if err != nil {
+		t.Fatalf("newNamespaceFilter: %v", err)
+	}
+	if filter.Selector == nil {
+		t.Fatalf("expected a selector to be parsed")
+	}
+	if !filter.RequireAnnotation {
+		t.Fatalf("expected RequireAnnotation to be true")
+	}
+	if !filter.Allow["team-a"] || !filter.Allow["team-b"] {
+		t.Fatalf("expected team-a and team-b in the allow set, got %+v", filter.Allow)
+	}
+	if !filter.Deny["team-c"] {
+		t.Fatalf("expected team-c in the deny set, got %+v", filter.Deny)
+	}
+	if filter.Allow["team-c"] {
+		t.Fatalf("team-c should not also be in the allow set")
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ecr"
+)
+
+// RegistryAuth is the credential material returned by a RegistryCredentialProvider
+// for a single registry endpoint.
+type RegistryAuth struct {
+	// Endpoint is the registry hostname or URL as it should appear in the
+	// .dockerconfigjson "auths" map, e.g. "https://123456789.dkr.ecr.us-east-1.amazonaws.com".
+	Endpoint string
+	// Auth is the base64-encoded "username:password" string docker expects.
+	Auth string
+	// ExpiresAt is when the returned credential stops being valid. Providers
+	// that hand out long-lived or static credentials may leave this zero.
+	ExpiresAt time.Time
+}
+
+// RegistryCredentialProvider knows how to mint credentials for one or more
+// container registries. Implementations should be safe to call repeatedly;
+// callers are responsible for deciding when a refresh is due.
+type RegistryCredentialProvider interface {
+	// Name identifies the provider in logs, e.g. "ecr", "gcr", "acr".
+	Name() string
+	// GetAuth fetches fresh credentials for every registry this provider manages.
+	GetAuth() ([]*RegistryAuth, error)
+}
+
+// dockerConfigJSON mirrors the on-disk .dockerconfigjson format so we can
+// render a merged secret covering every configured provider.
+type dockerConfigJSON struct {
+	Auths map[string]dockerConfigEntry `json:"auths"`
+}
+
+type dockerConfigEntry struct {
+	Auth  string `json:"auth"`
+	Email string `json:"email"`
+}
+
+// buildDockerConfigJSON merges the auth entries returned by every configured
+// provider into a single .dockerconfigjson document.
+func buildDockerConfigJSON(auths []*RegistryAuth) ([]byte, error) {
+	cfg := dockerConfigJSON{Auths: make(map[string]dockerConfigEntry, len(auths))}
+	for _, a := range auths {
+		cfg.Auths[a.Endpoint] = dockerConfigEntry{Auth: a.Auth, Email: "none"}
+	}
+	return json.Marshal(cfg)
+}
+
+// ECRAccount identifies one AWS account/region ECR should be reachable in.
+// When RoleARN is empty, the provider's base credentials (the IRSA identity
+// or whatever the default credential chain resolves) are used directly;
+// otherwise that role is assumed via STS before calling ECR.
+type ECRAccount struct {
+	Region      string
+	RoleARN     string
+	ExternalID  string
+	SessionName string
+}
+
+// ECRProvider fetches authorization tokens from AWS Elastic Container
+// Registry, one per configured account/region, so a single controller can
+// serve a central "shared services" registry account alongside per-team
+// workload accounts.
+type ECRProvider struct {
+	Accounts []ECRAccount
+}
+
+func (p *ECRProvider) Name() string {
+	return "ecr"
+}
+
+func (p *ECRProvider) GetAuth() ([]*RegistryAuth, error) {
+	auths := make([]*RegistryAuth, 0, len(p.Accounts))
+	for _, account := range p.Accounts {
+		auth, err := p.getAccountAuth(account)
+		if err != nil {
+			return nil, fmt.Errorf("region %s role %s: %v", account.Region, account.RoleARN, err)
+		}
+		auths = append(auths, auth)
+	}
+	return auths, nil
+}
+
+func (p *ECRProvider) getAccountAuth(account ECRAccount) (*RegistryAuth, error) {
+	logrus.Infof("Fetching ECR token for region: %s role: %s", account.Region, account.RoleARN)
+	sesh, err := ecrBaseSession(account.Region)
+	if err != nil {
+		return nil, fmt.Errorf("building base session: %v", err)
+	}
+
+	awscfg := aws.NewConfig().WithRegion(account.Region)
+	if account.RoleARN != "" {
+		awscfg = awscfg.WithCredentials(assumeRoleCredentials(sesh, account))
+	}
+	ecrClient := ecr.New(sesh, awscfg)
+
+	result, err := ecrClient.GetAuthorizationToken(&ecr.GetAuthorizationTokenInput{})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case ecr.ErrCodeServerException:
+				logrus.Errorf("%s: %s", ecr.ErrCodeServerException, aerr.Error())
+			case ecr.ErrCodeInvalidParameterException:
+				logrus.Errorf("%s: %s", ecr.ErrCodeInvalidParameterException, aerr.Error())
+			default:
+				logrus.Error(aerr.Error())
+			}
+		}
+		return nil, err
+	}
+	data := result.AuthorizationData[0]
+	auth := &RegistryAuth{
+		Endpoint: *data.ProxyEndpoint,
+		Auth:     *data.AuthorizationToken,
+	}
+	if data.ExpiresAt != nil {
+		auth.ExpiresAt = *data.ExpiresAt
+	}
+	return auth, nil
+}
+
+// GCRProvider fetches credentials for Google Container Registry / Artifact
+// Registry, either from a service-account JSON key file or, when none is
+// configured, from the node's workload identity metadata.
+type GCRProvider struct {
+	// Registry is the GCR/Artifact Registry hostname, e.g. "gcr.io" or
+	// "us-docker.pkg.dev".
+	Registry string
+	// ServiceAccountJSONPath, if set, points at a service-account key file
+	// used to mint access tokens. When empty, workload identity is used.
+	ServiceAccountJSONPath string
+}
+
+func (p *GCRProvider) Name() string {
+	return "gcr"
+}
+
+func (p *GCRProvider) GetAuth() ([]*RegistryAuth, error) {
+	var token string
+	var expiresAt time.Time
+	var err error
+	if p.ServiceAccountJSONPath != "" {
+		token, expiresAt, err = gcrTokenFromServiceAccount(p.ServiceAccountJSONPath)
+	} else {
+		logrus.Info("Fetching GCR token via workload identity")
+		token, expiresAt, err = gcrTokenFromWorkloadIdentity()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("gcr: %v", err)
+	}
+	return []*RegistryAuth{{
+		Endpoint:  p.Registry,
+		Auth:      base64.StdEncoding.EncodeToString([]byte("oauth2accesstoken:" + token)),
+		ExpiresAt: expiresAt,
+	}}, nil
+}
+
+// gcrTokenFromServiceAccount exchanges a service-account JSON key for an
+// OAuth2 access token scoped to devstorage/read-only (what GCR expects).
+func gcrTokenFromServiceAccount(path string) (string, time.Time, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("reading service account file: %v", err)
+	}
+	return exchangeGoogleServiceAccount(raw)
+}
+
+// gcrTokenFromWorkloadIdentity fetches a short-lived access token from the
+// GCE/GKE metadata server, relying on the node or pod's bound service account.
+func gcrTokenFromWorkloadIdentity() (string, time.Time, error) {
+	return fetchGoogleMetadataToken()
+}
+
+// ACRProvider fetches credentials for Azure Container Registry, either via a
+// service principal (client ID/secret) or the node's managed identity.
+type ACRProvider struct {
+	// Registry is the ACR login server, e.g. "myregistry.azurecr.io".
+	Registry     string
+	TenantID     string
+	ClientID     string
+	ClientSecret string
+	// UseMSI, when true, ignores ClientID/ClientSecret and authenticates via
+	// the instance's managed identity instead.
+	UseMSI bool
+}
+
+func (p *ACRProvider) Name() string {
+	return "acr"
+}
+
+func (p *ACRProvider) GetAuth() ([]*RegistryAuth, error) {
+	var refreshToken string
+	var expiresAt time.Time
+	var err error
+	if p.UseMSI {
+		logrus.Infof("Fetching ACR token for %s via MSI", p.Registry)
+		refreshToken, expiresAt, err = acrRefreshTokenFromMSI(p.Registry)
+	} else {
+		logrus.Infof("Fetching ACR token for %s via service principal", p.Registry)
+		refreshToken, expiresAt, err = acrRefreshTokenFromServicePrincipal(p.Registry, p.TenantID, p.ClientID, p.ClientSecret)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("acr: %v", err)
+	}
+	return []*RegistryAuth{{
+		Endpoint:  p.Registry,
+		Auth:      base64.StdEncoding.EncodeToString([]byte("00000000-0000-0000-0000-000000000000:" + refreshToken)),
+		ExpiresAt: expiresAt,
+	}}, nil
+}
+
+// DockerHubProvider supplies a static Docker Hub username/password pair.
+// Docker Hub has no token-exchange API worth calling on every refresh, so
+// the credential is simply whatever the operator configured.
+type DockerHubProvider struct {
+	Username string
+	Password string
+}
+
+func (p *DockerHubProvider) Name() string {
+	return "dockerhub"
+}
+
+func (p *DockerHubProvider) GetAuth() ([]*RegistryAuth, error) {
+	return []*RegistryAuth{{
+		Endpoint: "https://index.docker.io/v1/",
+		Auth:     base64.StdEncoding.EncodeToString([]byte(p.Username + ":" + p.Password)),
+	}}, nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// backoffState tracks consecutive failures for a provider and produces
+// exponential backoff durations with jitter, similar to the retry policy
+// used by istio's remote-secret sync.
+type backoffState struct {
+	attempt int
+	max     time.Duration
+}
+
+// next returns how long to wait before the next retry and advances the
+// attempt counter.
+func (b *backoffState) next() time.Duration {
+	base := time.Second << uint(b.attempt)
+	if base <= 0 || base > b.max {
+		base = b.max
+	}
+	b.attempt++
+	jitter := time.Duration(rand.Int63n(int64(base)/2 + 1))
+	return base/2 + jitter
+}
+
+// reset clears the attempt counter after a successful refresh.
+func (b *backoffState) reset() {
+	b.attempt = 0
+}
+
+// scheduledProvider wraps a RegistryCredentialProvider with a refresh
+// schedule derived from the credential's own expiry, so callers stop paying
+// for an API call every resync once a token is known to be fresh. On error
+// it serves the last good credentials (if any) while backing off retries.
+type scheduledProvider struct {
+	inner        RegistryCredentialProvider
+	safetyMargin float64
+
+	mu          sync.Mutex
+	backoff     *backoffState
+	cached      []*RegistryAuth
+	nextAttempt time.Time
+}
+
+// newScheduledProvider wraps inner so its credentials are refreshed at
+// safetyMargin of their remaining lifetime rather than on every call, and
+// retries failures with exponential backoff capped at maxBackoff.
+func newScheduledProvider(inner RegistryCredentialProvider, safetyMargin float64, maxBackoff time.Duration) *scheduledProvider {
+	return &scheduledProvider{
+		inner:        inner,
+		safetyMargin: safetyMargin,
+		backoff:      &backoffState{max: maxBackoff},
+	}
+}
+
+func (s *scheduledProvider) Name() string {
+	return s.inner.Name()
+}
+
+func (s *scheduledProvider) GetAuth() ([]*RegistryAuth, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if s.cached != nil && now.Before(s.nextAttempt) {
+		return s.cached, nil
+	}
+
+	auths, err := s.inner.GetAuth()
+	if err != nil {
+		ecrTokenRefreshTotal.WithLabelValues(s.inner.Name(), "error").Inc()
+		delay := s.backoff.next()
+		s.nextAttempt = now.Add(delay)
+		if s.cached != nil {
+			logrus.Errorf("Refresh for provider %s failed, serving cached credentials and retrying in %s: %v", s.inner.Name(), delay, err)
+			return s.cached, nil
+		}
+		logrus.Errorf("Refresh for provider %s failed, retrying in %s: %v", s.inner.Name(), delay, err)
+		return nil, err
+	}
+
+	ecrTokenRefreshTotal.WithLabelValues(s.inner.Name(), "success").Inc()
+	for _, a := range auths {
+		if !a.ExpiresAt.IsZero() {
+			ecrTokenExpirySeconds.WithLabelValues(s.inner.Name(), a.Endpoint).Set(float64(a.ExpiresAt.Unix()))
+		}
+	}
+	s.backoff.reset()
+	s.cached = auths
+	s.nextAttempt = s.nextRefreshTime(auths, now)
+	return auths, nil
+}
+
+// nextRefreshTime picks the earliest point at which any of auths should be
+// refreshed, at safetyMargin of its remaining lifetime. Credentials with no
+// expiry (static providers like Docker Hub) don't push the schedule out;
+// they're simply served from cache until another auth forces a refresh.
+func (s *scheduledProvider) nextRefreshTime(auths []*RegistryAuth, now time.Time) time.Time {
+	var earliest time.Time
+	for _, a := range auths {
+		if a.ExpiresAt.IsZero() {
+			continue
+		}
+		lifetime := a.ExpiresAt.Sub(now)
+		refreshAt := now.Add(time.Duration(float64(lifetime) * s.safetyMargin))
+		if earliest.IsZero() || refreshAt.Before(earliest) {
+			earliest = refreshAt
+		}
+	}
+	if earliest.IsZero() {
+		// No expiry information at all (e.g. only static providers
+		// configured) -- there's nothing to schedule around, so cache
+		// for 24 hours before refreshing again.
+		return now.Add(24 * time.Hour)
+	}
+	return earliest
+}
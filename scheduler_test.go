@@ -0,0 +1,93 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoffStateNext(t *testing.T) {
+	t.Run("stays positive and capped at max", func(t *testing.T) {
+		b := &backoffState{max: 30 * time.Second}
+		var prevAttempt int
+		for i := 0; i < 10; i++ {
+			d := b.next()
+			if d <= 0 {
+				t.Fatalf("attempt %d: got non-positive delay %s", i, d)
+			}
+			if d > b.max {
+				t.Fatalf("attempt %d: delay %s exceeded max %s", i, d, b.max)
+			}
+			prevAttempt++
+		}
+		if b.attempt != prevAttempt {
+			t.Fatalf("expected attempt counter to advance by one per call, got %d want %d", b.attempt, prevAttempt)
+		}
+	})
+
+	t.Run("zero max never produces a negative delay", func(t *testing.T) {
+		b := &backoffState{max: 0}
+		d := b.next()
+		if d < 0 {
+			t.Fatalf("expected a non-negative delay, got %s", d)
+		}
+	})
+
+	t.Run("reset clears the attempt counter", func(t *testing.T) {
+		b := &backoffState{max: time.Minute}
+		b.next()
+		b.next()
+		if b.attempt == 0 {
+			t.Fatalf("expected attempt counter to have advanced")
+		}
+		b.reset()
+		if b.attempt != 0 {
+			t.Fatalf("expected reset to clear the attempt counter, got %d", b.attempt)
+		}
+	})
+
+	t.Run("does not overflow into a negative base for a high attempt count", func(t *testing.T) {
+		b := &backoffState{max: time.Hour, attempt: 100}
+		d := b.next()
+		if d <= 0 || d > b.max {
+			t.Fatalf("expected delay capped within (0, %s], got %s", b.max, d)
+		}
+	})
+}
+
+func TestScheduledProviderNextRefreshTime(t *testing.T) {
+	s := &scheduledProvider{safetyMargin: 0.5}
+	now := time.Now()
+
+	t.Run("picks the earliest refresh time across multiple auths", func(t *testing.T) {
+		auths := []*RegistryAuth{
+			{Endpoint: "a", ExpiresAt: now.Add(10 * time.Minute)},
+			{Endpoint: "b", ExpiresAt: now.Add(2 * time.Minute)},
+		}
+		got := s.nextRefreshTime(auths, now)
+		want := now.Add(1 * time.Minute) // 50% of the 2-minute lifetime
+		if diff := got.Sub(want); diff < -time.Second || diff > time.Second {
+			t.Fatalf("nextRefreshTime = %s, want ~%s", got, want)
+		}
+	})
+
+	t.Run("ignores auths with no expiry", func(t *testing.T) {
+		auths := []*RegistryAuth{
+			{Endpoint: "static"},
+			{Endpoint: "ecr", ExpiresAt: now.Add(4 * time.Minute)},
+		}
+		got := s.nextRefreshTime(auths, now)
+		want := now.Add(2 * time.Minute)
+		if diff := got.Sub(want); diff < -time.Second || diff > time.Second {
+			t.Fatalf("nextRefreshTime = %s, want ~%s", got, want)
+		}
+	})
+
+	t.Run("falls back to a 24h cache when nothing has an expiry", func(t *testing.T) {
+		auths := []*RegistryAuth{{Endpoint: "static"}}
+		got := s.nextRefreshTime(auths, now)
+		want := now.Add(24 * time.Hour)
+		if diff := got.Sub(want); diff < -time.Second || diff > time.Second {
+			t.Fatalf("nextRefreshTime = %s, want ~%s", got, want)
+		}
+	})
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/labels"
+	"k8s.io/client-go/pkg/types"
+)
+
+// managedByLabel marks every secret this controller creates, so it can find
+// and garbage-collect its own old secrets without touching anything created
+// by another controller.
+const managedByLabel = "ecr-renew.io/managed-by"
+const managedByValue = "k8s-ecr-renew"
+
+// fingerprintAnnotation records the sha256 of the .dockerconfigjson a
+// managed secret was created with, so a reconcile can tell whether the
+// credentials actually changed without diffing raw secret data.
+const fingerprintAnnotation = "ecr-renew.io/fingerprint"
+
+// fingerprint returns the hex-encoded sha256 of data, suitable for the
+// fingerprintAnnotation.
+func fingerprint(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// ServiceAccountReconciler creates a freshly-named pull secret in a namespace
+// and wires it into every targeted ServiceAccount, without clobbering
+// ImagePullSecrets entries owned by anything else. Because the secret gets a
+// new name on every rotation (GenerateName, rather than a fixed predictable
+// name), old secrets are swept up afterwards via the managedByLabel.
+type ServiceAccountReconciler struct {
+	Client         *kubernetes.Clientset
+	Providers      []RegistryCredentialProvider
+	SecretBaseName string
+
+	// ServiceAccountNames, if non-empty, is the exact set of ServiceAccounts
+	// to reconcile. Takes precedence over ServiceAccountSelector.
+	ServiceAccountNames []string
+	// ServiceAccountSelector, if set, reconciles every ServiceAccount in the
+	// namespace matching this label selector.
+	ServiceAccountSelector labels.Selector
+}
+
+// parseServiceAccountTargets turns the --service-accounts and
+// --service-account-selector flag values into the name list / selector pair
+// NewServiceAccountReconciler expects.
+func parseServiceAccountTargets(namesFlag, selectorExpr string) ([]string, labels.Selector, error) {
+	var names []string
+	for _, name := range strings.Split(namesFlag, ",") {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+
+	if selectorExpr == "" {
+		return names, nil, nil
+	}
+	selector, err := labels.Parse(selectorExpr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing --service-account-selector: %v", err)
+	}
+	return names, selector, nil
+}
+
+// NewServiceAccountReconciler builds a ServiceAccountReconciler. When neither
+// names nor selector is set, it falls back to managing just "default" to
+// match the controller's original behavior.
+func NewServiceAccountReconciler(client *kubernetes.Clientset, providers []RegistryCredentialProvider,
+	secretBaseName string, names []string, selector labels.Selector) *ServiceAccountReconciler {
+	if len(names) == 0 && selector == nil {
+		names = []string{"default"}
+	}
+	return &ServiceAccountReconciler{
+		Client:                 client,
+		Providers:              providers,
+		SecretBaseName:         secretBaseName,
+		ServiceAccountNames:    names,
+		ServiceAccountSelector: selector,
+	}
+}
+
+// Reconcile ensures namespace's targeted ServiceAccounts reference a secret
+// carrying the current, merged pull credentials. When the rendered
+// credentials match a secret that's already wired into every target --
+// the common case between rotations -- this is a read-only no-op: no new
+// Secret is created, no ServiceAccount is patched, and nothing is deleted.
+func (r *ServiceAccountReconciler) Reconcile(namespace string) error {
+	data, err := renderDockerConfig(r.Providers)
+	if err != nil {
+		secretReconcileErrorsTotal.WithLabelValues(namespace).Inc()
+		return fmt.Errorf("rendering pull secret for %s: %v", namespace, err)
+	}
+	fp := fingerprint(data)
+
+	managed, err := r.listManagedSecrets(namespace)
+	if err != nil {
+		secretReconcileErrorsTotal.WithLabelValues(namespace).Inc()
+		return fmt.Errorf("listing managed secrets in %s: %v", namespace, err)
+	}
+
+	targets, err := r.targetServiceAccountNames(namespace)
+	if err != nil {
+		secretReconcileErrorsTotal.WithLabelValues(namespace).Inc()
+		return fmt.Errorf("listing target service accounts in %s: %v", namespace, err)
+	}
+
+	current := matchingSecret(managed, fp)
+	if current != nil && r.allReferenceSecret(namespace, targets, current.Name) {
+		return nil
+	}
+
+	managedNames := make(map[string]bool, len(managed)+1)
+	for _, s := range managed {
+		managedNames[s.Name] = true
+	}
+
+	secretName := ""
+	if current != nil {
+		secretName = current.Name
+	} else {
+		secret, err := r.createManagedSecret(namespace, data, fp)
+		if err != nil {
+			secretReconcileErrorsTotal.WithLabelValues(namespace).Inc()
+			return fmt.Errorf("creating pull secret in %s: %v", namespace, err)
+		}
+		secretName = secret.Name
+		managedNames[secret.Name] = true
+	}
+
+	patchFailed := false
+	for _, sa := range targets {
+		if err := r.patchServiceAccount(namespace, sa, secretName, managedNames); err != nil {
+			patchFailed = true
+			secretReconcileErrorsTotal.WithLabelValues(namespace).Inc()
+			logrus.Errorf("Could not patch ServiceAccount %s/%s: %v", namespace, sa, err)
+		}
+	}
+
+	// If any ServiceAccount failed to patch, it may still reference an old
+	// managed secret. Skip GC entirely rather than risk deleting a secret a
+	// failed SA is still relying on -- it'll be swept on the next reconcile
+	// that manages to patch everything.
+	if patchFailed {
+		return nil
+	}
+
+	if err := r.garbageCollectSecrets(namespace, managedNames, secretName); err != nil {
+		secretReconcileErrorsTotal.WithLabelValues(namespace).Inc()
+		logrus.Errorf("Could not garbage collect stale secrets in %s: %v", namespace, err)
+	}
+	return nil
+}
+
+// createManagedSecret creates a new, uniquely-named dockerconfigjson secret
+// carrying data, annotated with its fingerprint so future reconciles can
+// recognize and reuse it instead of rotating needlessly.
+func (r *ServiceAccountReconciler) createManagedSecret(namespace string, data []byte, fp string) (*v1.Secret, error) {
+	secret := &v1.Secret{
+		ObjectMeta: v1.ObjectMeta{
+			GenerateName: r.SecretBaseName + "-",
+			Labels:       map[string]string{managedByLabel: managedByValue},
+			Annotations:  map[string]string{fingerprintAnnotation: fp},
+		},
+		Data: map[string][]byte{".dockerconfigjson": data},
+		Type: "kubernetes.io/dockerconfigjson",
+	}
+	return r.Client.Secrets(namespace).Create(secret)
+}
+
+// listManagedSecrets returns every secret in namespace carrying
+// managedByLabel, i.e. everything (old or new) this controller is
+// responsible for.
+func (r *ServiceAccountReconciler) listManagedSecrets(namespace string) ([]v1.Secret, error) {
+	list, err := r.Client.Secrets(namespace).List(v1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s", managedByLabel, managedByValue),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+// matchingSecret returns the managed secret whose fingerprintAnnotation
+// equals fp, or nil if none of them do.
+func matchingSecret(managed []v1.Secret, fp string) *v1.Secret {
+	for i := range managed {
+		if managed[i].Annotations[fingerprintAnnotation] == fp {
+			return &managed[i]
+		}
+	}
+	return nil
+}
+
+// allReferenceSecret reports whether every named ServiceAccount in
+// namespace already has secretName in its ImagePullSecrets.
+func (r *ServiceAccountReconciler) allReferenceSecret(namespace string, targets []string, secretName string) bool {
+	for _, name := range targets {
+		sa, err := r.Client.ServiceAccounts(namespace).Get(name)
+		if err != nil {
+			return false
+		}
+		if !serviceAccountHasPullSecret(sa, secretName) {
+			return false
+		}
+	}
+	return true
+}
+
+// serviceAccountHasPullSecret reports whether sa's ImagePullSecrets already
+// includes secretName.
+func serviceAccountHasPullSecret(sa *v1.ServiceAccount, secretName string) bool {
+	for _, ref := range sa.ImagePullSecrets {
+		if ref.Name == secretName {
+			return true
+		}
+	}
+	return false
+}
+
+// targetServiceAccountNames resolves which ServiceAccounts in namespace
+// should be patched, from either the explicit name list or the selector.
+func (r *ServiceAccountReconciler) targetServiceAccountNames(namespace string) ([]string, error) {
+	if len(r.ServiceAccountNames) > 0 {
+		return r.ServiceAccountNames, nil
+	}
+
+	list, err := r.Client.ServiceAccounts(namespace).List(v1.ListOptions{
+		LabelSelector: r.ServiceAccountSelector.String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(list.Items))
+	for _, sa := range list.Items {
+		names = append(names, sa.Name)
+	}
+	return names, nil
+}
+
+// patchServiceAccount strategic-merge-patches saName's imagePullSecrets to
+// reference currentSecretName. References to other managed secrets (prior
+// rotations) are dropped so the list doesn't grow without bound; any
+// reference not in managedSecrets -- i.e. added by something else -- is left
+// untouched.
+func (r *ServiceAccountReconciler) patchServiceAccount(namespace, saName, currentSecretName string, managedSecrets map[string]bool) error {
+	sa, err := r.Client.ServiceAccounts(namespace).Get(saName)
+	if err != nil {
+		return err
+	}
+
+	refs := make([]v1.LocalObjectReference, 0, len(sa.ImagePullSecrets)+1)
+	seen := map[string]bool{}
+	for _, ref := range sa.ImagePullSecrets {
+		if managedSecrets[ref.Name] && ref.Name != currentSecretName {
+			continue
+		}
+		if seen[ref.Name] {
+			continue
+		}
+		refs = append(refs, ref)
+		seen[ref.Name] = true
+	}
+	if !seen[currentSecretName] {
+		refs = append(refs, v1.LocalObjectReference{Name: currentSecretName})
+	}
+
+	patch, err := json.Marshal(struct {
+		ImagePullSecrets []v1.LocalObjectReference `json:"imagePullSecrets"`
+	}{refs})
+	if err != nil {
+		return err
+	}
+
+	_, err = r.Client.ServiceAccounts(namespace).Patch(saName, types.StrategicMergePatchType, patch)
+	return err
+}
+
+// garbageCollectSecrets deletes every managed secret other than
+// currentSecretName, i.e. the ones left behind by earlier rotations.
+func (r *ServiceAccountReconciler) garbageCollectSecrets(namespace string, managedSecrets map[string]bool, currentSecretName string) error {
+	var errs []string
+	for name := range managedSecrets {
+		if name == currentSecretName {
+			continue
+		}
+		logrus.Infof("Garbage collecting stale managed secret %s/%s", namespace, name)
+		if err := r.Client.Secrets(namespace).Delete(name, &v1.DeleteOptions{}); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+func TestFingerprintIsStableAndContentSensitive(t *testing.T) {
+	a := fingerprint([]byte(`{"auths":{"x":"y"}}`))
+	b := fingerprint([]byte(`{"auths":{"x":"y"}}`))
+	if a != b {
+		t.Fatalf("fingerprint of identical data differed: %q vs %q", a, b)
+	}
+	c := fingerprint([]byte(`{"auths":{"x":"z"}}`))
+	if a == c {
+		t.Fatalf("fingerprint did not change when data changed")
+	}
+}
+
+func TestMatchingSecret(t *testing.T) {
+	managed := []v1.Secret{
+		{ObjectMeta: v1.ObjectMeta{Name: "a", Annotations: map[string]string{fingerprintAnnotation: "111"}}},
+		{ObjectMeta: v1.ObjectMeta{Name: "b", Annotations: map[string]string{fingerprintAnnotation: "222"}}},
+	}
+
+	tests := []struct {
+		name string
+		fp   string
+		want string // expected secret name, "" for no match
+	}{
+		{"matches first", "111", "a"},
+		{"matches second", "222", "b"},
+		{"no match", "333", ""},
+		{"empty managed set", "111", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set := managed
+			if tt.name == "empty managed set" {
+				set = nil
+			}
+			got := matchingSecret(set, tt.fp)
+			switch {
+			case tt.want == "" && got != nil:
+				t.Fatalf("expected no match, got %q", got.Name)
+			case tt.want != "" && got == nil:
+				t.Fatalf("expected match %q, got none", tt.want)
+			case tt.want != "" && got.Name != tt.want:
+				t.Fatalf("expected match %q, got %q", tt.want, got.Name)
+			}
+		})
+	}
+}
+
+func TestServiceAccountHasPullSecret(t *testing.T) {
+	sa := &v1.ServiceAccount{
+		ImagePullSecrets: []v1.LocalObjectReference{
+			{Name: "unrelated-secret"},
+			{Name: "current-secret"},
+		},
+	}
+
+	if !serviceAccountHasPullSecret(sa, "current-secret") {
+		t.Fatalf("expected current-secret to be found")
+	}
+	if serviceAccountHasPullSecret(sa, "missing-secret") {
+		t.Fatalf("did not expect missing-secret to be found")
+	}
+
+	empty := &v1.ServiceAccount{}
+	if serviceAccountHasPullSecret(empty, "current-secret") {
+		t.Fatalf("expected no match against a ServiceAccount with no ImagePullSecrets")
+	}
+}
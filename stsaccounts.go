@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// ecrBaseSession builds the session used as the starting identity for a
+// given region, before any cross-account role assumption. When IRSA env
+// vars are present it authenticates as the bound IAM role via a web
+// identity token rather than relying on the default credential chain to
+// pick them up, so this also works on older SDK builds that predate
+// built-in IRSA support.
+func ecrBaseSession(region string) (*session.Session, error) {
+	sesh, err := session.NewSession(aws.NewConfig().WithRegion(region))
+	if err != nil {
+		return nil, err
+	}
+
+	tokenFile := os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE")
+	roleARN := os.Getenv("AWS_ROLE_ARN")
+	if tokenFile == "" || roleARN == "" {
+		return sesh, nil
+	}
+
+	sessionName := os.Getenv("AWS_ROLE_SESSION_NAME")
+	if sessionName == "" {
+		sessionName = "k8s-ecr-renew"
+	}
+	sesh.Config.Credentials = stscreds.NewWebIdentityCredentials(sesh, roleARN, sessionName, tokenFile)
+	return sesh, nil
+}
+
+// assumeRoleCredentials wraps sesh's base identity with an STS
+// AssumeRole call into account.RoleARN.
+func assumeRoleCredentials(sesh *session.Session, account ECRAccount) *credentials.Credentials {
+	return stscreds.NewCredentials(sesh, account.RoleARN, func(p *stscreds.AssumeRoleProvider) {
+		if account.ExternalID != "" {
+			p.ExternalID = aws.String(account.ExternalID)
+		}
+		if account.SessionName != "" {
+			p.RoleSessionName = account.SessionName
+		}
+	})
+}
+
+// parseECRAccounts parses the --ecr-accounts flag into a list of
+// ECRAccount entries. Each entry is "region|roleArn|externalId|sessionName",
+// with roleArn, externalId and sessionName all optional; entries are
+// comma-separated. An empty flag falls back to a single entry using
+// defaultRegion and no role assumption, matching the controller's original
+// single-account behavior.
+func parseECRAccounts(flagValue, defaultRegion string) ([]ECRAccount, error) {
+	if flagValue == "" {
+		return []ECRAccount{{Region: defaultRegion}}, nil
+	}
+
+	var accounts []ECRAccount
+	for _, entry := range strings.Split(flagValue, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		fields := strings.Split(entry, "|")
+		if fields[0] == "" {
+			return nil, fmt.Errorf("invalid --ecr-accounts entry %q: region is required", entry)
+		}
+		account := ECRAccount{Region: fields[0]}
+		if len(fields) > 1 {
+			account.RoleARN = fields[1]
+		}
+		if len(fields) > 2 {
+			account.ExternalID = fields[2]
+		}
+		if len(fields) > 3 {
+			account.SessionName = fields[3]
+		}
+		accounts = append(accounts, account)
+	}
+	return accounts, nil
+}